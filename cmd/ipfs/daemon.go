@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	_ "expvar"
 	"fmt"
 	"net/http"
@@ -9,8 +8,10 @@ import (
 	"os"
 	"sort"
 	"sync"
+	"time"
 
 	_ "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/codahale/metrics/runtime"
+	syncds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	ma "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr-net"
 
@@ -24,20 +25,27 @@ import (
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 	util "github.com/ipfs/go-ipfs/util"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
 )
 
 const (
-	initOptionKwd             = "init"
-	routingOptionKwd          = "routing"
-	routingOptionSupernodeKwd = "supernode"
-	mountKwd                  = "mount"
-	writableKwd               = "writable"
-	ipfsMountKwd              = "mount-ipfs"
-	ipnsMountKwd              = "mount-ipns"
-	unrestrictedApiAccessKwd  = "unrestricted-api"
-	unencryptTransportKwd     = "disable-transport-encryption"
-	// apiAddrKwd    = "address-api"
-	// swarmAddrKwd  = "address-swarm"
+	initOptionKwd                   = "init"
+	routingOptionKwd                = "routing"
+	routingOptionSupernodeKwd       = "supernode"
+	routingOptionSupernodeServerKwd = "supernode-server"
+	routingOptionCustomKwd          = "custom"
+	mountKwd                        = "mount"
+	writableKwd                     = "writable"
+	ipfsMountKwd                    = "mount-ipfs"
+	ipnsMountKwd                    = "mount-ipns"
+	unrestrictedApiAccessKwd        = "unrestricted-api"
+	unencryptTransportKwd           = "disable-transport-encryption"
+	migrateKwd                      = "migrate"
+	offlineKwd                      = "offline"
+	pubsubKwd                       = "enable-pubsub-experiment"
+	namesysPubsubKwd                = "enable-namesys-pubsub"
+	apiAddrKwd                      = "api"
+	swarmAddrKwd                    = "swarm"
 )
 
 var daemonCmd = &cmds.Command{
@@ -80,17 +88,19 @@ run this then restart the daemon:
 
 	Options: []cmds.Option{
 		cmds.BoolOption(initOptionKwd, "Initialize IPFS with default settings if not already initialized"),
-		cmds.StringOption(routingOptionKwd, "Overrides the routing option (dht, supernode)"),
+		cmds.StringOption(routingOptionKwd, "Overrides the routing option (dht, supernode, supernode-server, custom)"),
 		cmds.BoolOption(mountKwd, "Mounts IPFS to the filesystem"),
 		cmds.BoolOption(writableKwd, "Enable writing objects (with POST, PUT and DELETE)"),
 		cmds.StringOption(ipfsMountKwd, "Path to the mountpoint for IPFS (if using --mount)"),
 		cmds.StringOption(ipnsMountKwd, "Path to the mountpoint for IPNS (if using --mount)"),
 		cmds.BoolOption(unrestrictedApiAccessKwd, "Allow API access to unlisted hashes"),
 		cmds.BoolOption(unencryptTransportKwd, "Disable transport encryption (for debugging protocols)"),
-
-		// TODO: add way to override addresses. tricky part: updating the config if also --init.
-		// cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config)"),
-		// cmds.StringOption(swarmAddrKwd, "Address for the swarm socket (overrides config)"),
+		cmds.BoolOption(migrateKwd, "If true, assume yes at the repo migration prompt. If false, assume no."),
+		cmds.BoolOption(offlineKwd, "Run offline. Do not connect to the rest of the network, but still serve the local HTTP API and gateway"),
+		cmds.BoolOption(pubsubKwd, "Instantiate the pubsub experiment and expose the pubsub API commands"),
+		cmds.BoolOption(namesysPubsubKwd, "Use the pubsub experiment for namesys (IPNS) publish and resolve"),
+		cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config, this run only)"),
+		cmds.StringsOption(swarmAddrKwd, "Address for the swarm socket(s) (overrides config, this run only)"),
 	},
 	Subcommands: map[string]*cmds.Command{},
 	Run:         daemonFunc,
@@ -151,6 +161,14 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		}
 	}
 
+	// the repo may be on an older version than this binary expects; bring
+	// it up to date _before_ fsrepo.Open, which otherwise fails hard on a
+	// version mismatch
+	if err := maybeRunMigrations(req); err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
 	// acquire the repo lock _before_ constructing a node. we need to make
 	// sure we are permitted to access the resources (datastore, etc.)
 	repo, err := fsrepo.Open(req.InvocContext().ConfigRoot)
@@ -165,16 +183,83 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		return
 	}
 
+	offline, offlineSet, err := req.Option(offlineKwd).Bool()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		repo.Close() // because ownership hasn't been transferred to the node
+		return
+	}
+	if !offlineSet {
+		offline = cfg.Daemon.Offline
+	}
+
+	pubsubEnabled, pubsubSet, err := req.Option(pubsubKwd).Bool()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		repo.Close() // because ownership hasn't been transferred to the node
+		return
+	}
+	if !pubsubSet {
+		pubsubEnabled = cfg.Pubsub.Enabled
+	}
+
+	namesysPubsubEnabled, namesysPubsubSet, err := req.Option(namesysPubsubKwd).Bool()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		repo.Close() // because ownership hasn't been transferred to the node
+		return
+	}
+	if !namesysPubsubSet {
+		namesysPubsubEnabled = cfg.Ipns.UsePubsub
+	}
+
+	swarmAddrs, swarmAddrsFound, err := req.Option(swarmAddrKwd).Strings()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		repo.Close() // because ownership hasn't been transferred to the node
+		return
+	}
+	if swarmAddrsFound && len(swarmAddrs) > 0 {
+		for _, addr := range swarmAddrs {
+			if _, err := ma.NewMultiaddr(addr); err != nil {
+				res.SetError(fmt.Errorf("invalid --%s address %q: %s", swarmAddrKwd, addr, err), cmds.ErrNormal)
+				repo.Close() // because ownership hasn't been transferred to the node
+				return
+			}
+		}
+		fmt.Printf("Overriding config Addresses.Swarm with %s (not written to config)\n", swarmAddrs)
+		// splice the override into the repo's in-memory config, never persisted to disk,
+		// so the node builder picks it up without anyone calling repo.SetConfig
+		repo.Config().Addresses.Swarm = swarmAddrs
+	}
+
 	// Start assembling corebuilder
-	nb := core.NewNodeBuilder().Online()
+	nb := core.NewNodeBuilder()
+	if offline {
+		nb.Offline()
+	} else {
+		nb.Online()
+	}
 	nb.SetRepo(repo)
 
-	routingOption, _, err := req.Option(routingOptionKwd).String()
+	if pubsubEnabled || namesysPubsubEnabled {
+		nb.SetPubSub(true)
+	}
+	if namesysPubsubEnabled {
+		nb.SetNamesysPubsub(true)
+	}
+
+	routingOption, routingOptionSet, err := req.Option(routingOptionKwd).String()
 	if err != nil {
 		res.SetError(err, cmds.ErrNormal)
 		return
 	}
-	if routingOption == routingOptionSupernodeKwd {
+	if !routingOptionSet && repo.Config().SupernodeRouting.Server {
+		routingOption = routingOptionSupernodeServerKwd
+	}
+	isSupernodeServer := routingOption == routingOptionSupernodeServerKwd
+	switch routingOption {
+	case routingOptionSupernodeKwd:
 		servers, err := repo.Config().SupernodeRouting.ServerIPFSAddrs()
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
@@ -189,6 +274,13 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 			})
 		}
 		nb.SetRouting(corerouting.SupernodeClient(infos...))
+
+	case routingOptionSupernodeServerKwd:
+		ds := ds2.CloserWrap(syncds.MutexWrap(repo.Datastore()))
+		nb.SetRouting(corerouting.SupernodeServer(ds))
+
+	case routingOptionCustomKwd:
+		nb.SetRouting(corerouting.Custom(repo.Config().Routing))
 	}
 
 	node, err := nb.Build(req.Context())
@@ -198,8 +290,6 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		return
 	}
 
-	printSwarmAddrs(node)
-
 	defer func() {
 		// We wait for the node to close first, as the node has children
 		// that it will wait for before closing, such as the API server.
@@ -212,12 +302,28 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		}
 	}()
 
+	if !offline {
+		printSwarmAddrs(node)
+	}
+
+	if isSupernodeServer && !offline {
+		printSupernodeServerInfo(node)
+	}
+
+	// pick up any sockets systemd passed us via socket activation, so a
+	// `Socket` unit can hand off an already-bound port across restarts
+	sockets, err := systemdListeners()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
 	req.InvocContext().ConstructNode = func() (*core.IpfsNode, error) {
 		return node, nil
 	}
 
 	// construct api endpoint - every time
-	err, apiErrc := serveHTTPApi(req)
+	err, apiErrc := serveHTTPApi(req, sockets)
 	if err != nil {
 		res.SetError(err, cmds.ErrNormal)
 		return
@@ -227,11 +333,21 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 	var gwErrc <-chan error
 	if len(cfg.Addresses.Gateway) > 0 {
 		var err error
-		err, gwErrc = serveHTTPGateway(req)
+		var watched []*watchedKeySet
+		err, gwErrc, watched = serveHTTPGateway(req, sockets)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
 		}
+		for _, w := range watched {
+			defer w.Close()
+		}
+	}
+
+	// tell systemd (if we're running under it) that we're ready to serve,
+	// and who to send signals to
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1\n", os.Getpid())); err != nil {
+		log.Warningf("sd_notify failed: %s", err)
 	}
 
 	// construct fuse mountpoints - if the user provided the --mount flag
@@ -258,23 +374,39 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 }
 
 // serveHTTPApi collects options, creates listener, prints status message and starts serving requests
-func serveHTTPApi(req cmds.Request) (error, <-chan error) {
+func serveHTTPApi(req cmds.Request, sockets map[string]manet.Listener) (error, <-chan error) {
 	cfg, err := req.InvocContext().GetConfig()
 	if err != nil {
 		return fmt.Errorf("serveHTTPApi: GetConfig() failed: %s", err), nil
 	}
 
-	apiMaddr, err := ma.NewMultiaddr(cfg.Addresses.API)
+	apiAddr, apiAddrFound, err := req.Option(apiAddrKwd).String()
 	if err != nil {
-		return fmt.Errorf("serveHTTPApi: invalid API address: %q (err: %s)", cfg.Addresses.API, err), nil
+		return fmt.Errorf("serveHTTPApi: Option(%s) failed: %s", apiAddrKwd, err), nil
+	}
+	if !apiAddrFound {
+		apiAddr = cfg.Addresses.API
+	} else {
+		fmt.Printf("Overriding config Addresses.API with %s (not written to config)\n", apiAddr)
 	}
 
-	apiLis, err := manet.Listen(apiMaddr)
-	if err != nil {
-		return fmt.Errorf("serveHTTPApi: manet.Listen(%s) failed: %s", apiMaddr, err), nil
+	var apiLis manet.Listener
+	if sockLis, ok := sockets["api"]; ok {
+		log.Info("using systemd socket activation for API listener")
+		apiLis = sockLis
+	} else {
+		apiMaddr, err := ma.NewMultiaddr(apiAddr)
+		if err != nil {
+			return fmt.Errorf("serveHTTPApi: invalid API address: %q (err: %s)", apiAddr, err), nil
+		}
+
+		apiLis, err = manet.Listen(apiMaddr)
+		if err != nil {
+			return fmt.Errorf("serveHTTPApi: manet.Listen(%s) failed: %s", apiMaddr, err), nil
+		}
 	}
 	// we might have listened to /tcp/0 - lets see what we are listing on
-	apiMaddr = apiLis.Multiaddr()
+	apiMaddr := apiLis.Multiaddr()
 	fmt.Printf("API server listening on %s\n", apiMaddr)
 
 	unrestricted, _, err := req.Option(unrestrictedApiAccessKwd).Bool()
@@ -307,6 +439,17 @@ func serveHTTPApi(req cmds.Request) (error, <-chan error) {
 		corehttp.PrometheusOption("/debug/metrics/prometheus"),
 	}
 
+	pubsubEnabled, pubsubSet, err := req.Option(pubsubKwd).Bool()
+	if err != nil {
+		return fmt.Errorf("serveHTTPApi: Option(%s) failed: %s", pubsubKwd, err), nil
+	}
+	if !pubsubSet {
+		pubsubEnabled = cfg.Pubsub.Enabled
+	}
+	if pubsubEnabled {
+		opts = append(opts, corehttp.PubsubOption())
+	}
+
 	if len(cfg.Gateway.RootRedirect) > 0 {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
@@ -337,32 +480,48 @@ func printSwarmAddrs(node *core.IpfsNode) {
 	}
 }
 
-// serveHTTPGateway collects options, creates listener, prints status message and starts serving requests
-func serveHTTPGateway(req cmds.Request) (error, <-chan error) {
-	cfg, err := req.InvocContext().GetConfig()
-	if err != nil {
-		return fmt.Errorf("serveHTTPGateway: GetConfig() failed: %s", err), nil
+// printSupernodeServerInfo prints this node's peer info so that it can be
+// copied into the SupernodeRouting.Servers config of the clients it serves
+func printSupernodeServerInfo(node *core.IpfsNode) {
+	info := peer.PeerInfo{ID: node.Identity, Addrs: node.PeerHost.Addrs()}
+	fmt.Printf("Supernode server listening, use as a routing server with:\n")
+	for _, addr := range info.Addrs {
+		fmt.Printf("  %s/ipfs/%s\n", addr, info.ID.Pretty())
 	}
+}
 
-	gatewayMaddr, err := ma.NewMultiaddr(cfg.Addresses.Gateway)
+// serveHTTPGateway collects options, creates listener, prints status message and starts serving requests
+func serveHTTPGateway(req cmds.Request, sockets map[string]manet.Listener) (error, <-chan error, []*watchedKeySet) {
+	cfg, err := req.InvocContext().GetConfig()
 	if err != nil {
-		return fmt.Errorf("serveHTTPGateway: invalid gateway address: %q (err: %s)", cfg.Addresses.Gateway, err), nil
+		return fmt.Errorf("serveHTTPGateway: GetConfig() failed: %s", err), nil, nil
 	}
 
 	writable, writableOptionFound, err := req.Option(writableKwd).Bool()
 	if err != nil {
-		return fmt.Errorf("serveHTTPGateway: req.Option(%s) failed: %s", writableKwd, err), nil
+		return fmt.Errorf("serveHTTPGateway: req.Option(%s) failed: %s", writableKwd, err), nil, nil
 	}
 	if !writableOptionFound {
 		writable = cfg.Gateway.Writable
 	}
 
-	gwLis, err := manet.Listen(gatewayMaddr)
-	if err != nil {
-		return fmt.Errorf("serveHTTPGateway: manet.Listen(%s) failed: %s", gatewayMaddr, err), nil
+	var gwLis manet.Listener
+	if sockLis, ok := sockets["gateway"]; ok {
+		log.Info("using systemd socket activation for gateway listener")
+		gwLis = sockLis
+	} else {
+		gatewayMaddr, err := ma.NewMultiaddr(cfg.Addresses.Gateway)
+		if err != nil {
+			return fmt.Errorf("serveHTTPGateway: invalid gateway address: %q (err: %s)", cfg.Addresses.Gateway, err), nil, nil
+		}
+
+		gwLis, err = manet.Listen(gatewayMaddr)
+		if err != nil {
+			return fmt.Errorf("serveHTTPGateway: manet.Listen(%s) failed: %s", gatewayMaddr, err), nil, nil
+		}
 	}
 	// we might have listened to /tcp/0 - lets see what we are listing on
-	gatewayMaddr = gwLis.Multiaddr()
+	gatewayMaddr := gwLis.Multiaddr()
 
 	if writable {
 		fmt.Printf("Gateway (writable) server listening on %s\n", gatewayMaddr)
@@ -370,24 +529,42 @@ func serveHTTPGateway(req cmds.Request) (error, <-chan error) {
 		fmt.Printf("Gateway (readonly) server listening on %s\n", gatewayMaddr)
 	}
 
+	node, err := req.InvocContext().ConstructNode()
+	if err != nil {
+		return fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err), nil, nil
+	}
+
+	refreshInterval := time.Duration(cfg.Gateway.ListRefreshInterval) * time.Second
+	if refreshInterval <= 0 {
+		refreshInterval = 5 * time.Minute
+	}
+
 	var blacklist key.KeySet
 	var whitelist key.KeySet
+	var watched []*watchedKeySet
+
 	if cfg.Gateway.BlackList != "" {
-		l, err := loadKeySetFromURL(cfg.Gateway.BlackList)
+		w, err := watchKeySetURL(cfg.Gateway.BlackList, node, refreshInterval)
 		if err != nil {
-			return err, nil
+			return fmt.Errorf("serveHTTPGateway: loading Gateway.BlackList: %s", err), nil, nil
 		}
-		blacklist = l
+		blacklist = w
+		watched = append(watched, w)
 	}
 
 	if cfg.Gateway.WhiteList != "" {
-		l, err := loadKeySetFromURL(cfg.Gateway.WhiteList)
+		w, err := watchKeySetURL(cfg.Gateway.WhiteList, node, refreshInterval)
 		if err != nil {
-			return err, nil
+			return fmt.Errorf("serveHTTPGateway: loading Gateway.WhiteList: %s", err), nil, nil
 		}
-		whitelist = l
+		whitelist = w
+		watched = append(watched, w)
 	}
 
+	// SIGHUP forces an immediate refresh, so operators don't have to wait
+	// out Gateway.ListRefreshInterval to push a takedown
+	watchSIGHUP(watched...)
+
 	gateway := corehttp.Gateway{
 		Config: corehttp.GatewayConfig{
 			BlackList: blacklist,
@@ -405,35 +582,33 @@ func serveHTTPGateway(req cmds.Request) (error, <-chan error) {
 		opts = append(opts, corehttp.RedirectOption("", cfg.Gateway.RootRedirect))
 	}
 
-	node, err := req.InvocContext().ConstructNode()
-	if err != nil {
-		return fmt.Errorf("serveHTTPGateway: ConstructNode() failed: %s", err), nil
-	}
-
 	errc := make(chan error)
 	go func() {
 		errc <- corehttp.Serve(node, gwLis.NetListener(), opts...)
 		close(errc)
 	}()
-	return nil, errc
+	return nil, errc, watched
 }
 
-func loadKeySetFromURL(url string) (key.KeySet, error) {
-	resp, err := http.Get(url)
+// watchKeySetURL builds the KeySetProvider matching url's scheme and wraps
+// it in a watchedKeySet that refreshes every interval (and, for file://
+// sources, immediately on any change to the file).
+func watchKeySetURL(rawurl string, node *core.IpfsNode, interval time.Duration) (*watchedKeySet, error) {
+	provider, err := keySetProviderForURL(rawurl, node)
 	if err != nil {
 		return nil, err
 	}
 
-	ks := key.NewKeySet()
-	scan := bufio.NewScanner(resp.Body)
-	for scan.Scan() {
-		k := key.B58KeyDecode(scan.Text())
-		if k == "" {
-			return nil, fmt.Errorf("invalid key in set")
-		}
-		ks.Add(k)
+	w, err := newWatchedKeySet(provider, interval)
+	if err != nil {
+		return nil, err
 	}
-	return key.Threadsafe(ks), nil
+
+	if fp, ok := provider.(*fileKeySetProvider); ok {
+		watchFile(fp.path, w)
+	}
+
+	return w, nil
 }
 
 //collects options and opens the fuse mountpoint
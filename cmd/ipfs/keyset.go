@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	fsnotify "github.com/ipfs/go-ipfs/Godeps/_workspace/src/gopkg.in/fsnotify.v1"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	"github.com/ipfs/go-ipfs/core"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+)
+
+// KeySetProvider fetches the current contents of a gateway black/whitelist
+// from some source. Every call consults the source again (httpKeySetProvider
+// hands back the KeySet of its previous fetch when the server answers 304);
+// callers that want caching or hot-reload should wrap it in a watchedKeySet.
+type KeySetProvider interface {
+	LoadKeySet() (key.KeySet, error)
+}
+
+// keySetProviderForURL picks the KeySetProvider implementation matching
+// rawurl's scheme (http/https, file, or ipfs).
+func keySetProviderForURL(rawurl string, node *core.IpfsNode) (KeySetProvider, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key set URL %q: %s", rawurl, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpKeySetProvider{url: rawurl, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case "file":
+		return &fileKeySetProvider{path: u.Path}, nil
+	case "ipfs":
+		return &ipfsKeySetProvider{name: u.Host + u.Path, node: node}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key set URL scheme %q", u.Scheme)
+	}
+}
+
+func parseKeySet(r io.Reader) (key.KeySet, error) {
+	ks := key.NewKeySet()
+	scan := bufio.NewScanner(bufio.NewReader(r))
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" {
+			continue
+		}
+		k := key.B58KeyDecode(line)
+		if k == "" {
+			return nil, fmt.Errorf("invalid key in set: %q", line)
+		}
+		ks.Add(k)
+	}
+	return key.Threadsafe(ks), nil
+}
+
+// httpKeySetProvider fetches a key set over HTTP(S), using ETag/Last-Modified
+// so a background refresher can poll cheaply: a 304 means the in-memory
+// KeySet from the previous fetch is still current.
+type httpKeySetProvider struct {
+	url    string
+	client *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	cached       key.KeySet
+}
+
+func (p *httpKeySetProvider) LoadKeySet() (key.KeySet, error) {
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+	p.mu.Unlock()
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.cached, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keyset: GET %s: %s", p.url, resp.Status)
+	}
+
+	ks, err := parseKeySet(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
+	p.cached = ks
+	p.mu.Unlock()
+
+	return ks, nil
+}
+
+// fileKeySetProvider reads a key set from a local file. Besides the periodic
+// refresh of watchedKeySet, edits to the file trigger a reload via fsnotify;
+// see watchFile.
+type fileKeySetProvider struct {
+	path string
+}
+
+func (p *fileKeySetProvider) LoadKeySet() (key.KeySet, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseKeySet(f)
+}
+
+// ipfsKeySetProvider resolves an IPNS name to the object containing the key
+// set and fetches it through the local node, so publishers can update the
+// list just by re-publishing the IPNS record.
+type ipfsKeySetProvider struct {
+	name string
+	node *core.IpfsNode
+}
+
+func (p *ipfsKeySetProvider) LoadKeySet() (key.KeySet, error) {
+	ctx := p.node.Context()
+	resolved, err := namesys.Resolve(ctx, p.node.Namesys, p.name)
+	if err != nil {
+		return nil, fmt.Errorf("keyset: resolving %q: %s", p.name, err)
+	}
+
+	dn, err := p.node.Resolver.ResolvePath(ctx, resolved)
+	if err != nil {
+		return nil, fmt.Errorf("keyset: fetching %q: %s", resolved, err)
+	}
+
+	r, err := uio.NewDagReader(ctx, dn, p.node.DAG)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeySet(r)
+}
+
+// watchedKeySet is a key.KeySet backed by a KeySetProvider, refreshed
+// periodically (and on demand) in the background so lookups never block on
+// network or disk I/O. It's safe for concurrent use.
+type watchedKeySet struct {
+	provider KeySetProvider
+	interval time.Duration
+
+	current atomic.Value // holds key.KeySet
+
+	refresh chan struct{}
+	closing chan struct{}
+}
+
+// newWatchedKeySet does an initial synchronous load (so startup fails fast
+// on a bad list) and then starts a background refresher.
+func newWatchedKeySet(provider KeySetProvider, interval time.Duration) (*watchedKeySet, error) {
+	ks, err := provider.LoadKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &watchedKeySet{
+		provider: provider,
+		interval: interval,
+		refresh:  make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+	}
+	w.current.Store(ks)
+
+	go w.loop()
+	return w, nil
+}
+
+func (w *watchedKeySet) loop() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.reload()
+		case <-w.refresh:
+			w.reload()
+		case <-w.closing:
+			return
+		}
+	}
+}
+
+func (w *watchedKeySet) reload() {
+	ks, err := w.provider.LoadKeySet()
+	if err != nil {
+		log.Warningf("keyset: refresh failed, keeping previous list: %s", err)
+		return
+	}
+	w.current.Store(ks)
+}
+
+// Refresh forces an immediate, out-of-band reload; used by the SIGHUP
+// handler so operators don't have to wait out ListRefreshInterval.
+func (w *watchedKeySet) Refresh() {
+	select {
+	case w.refresh <- struct{}{}:
+	default:
+		// a refresh is already pending
+	}
+}
+
+func (w *watchedKeySet) Close() {
+	close(w.closing)
+}
+
+func (w *watchedKeySet) Add(k key.Key) {
+	w.current.Load().(key.KeySet).Add(k)
+}
+
+func (w *watchedKeySet) Has(k key.Key) bool {
+	return w.current.Load().(key.KeySet).Has(k)
+}
+
+// watchFile is the fsnotify hook used by callers that built a
+// fileKeySetProvider, so edits to the list take effect without waiting for
+// the next tick.
+func watchFile(path string, w *watchedKeySet) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warningf("keyset: could not watch %s for changes: %s", path, err)
+		return
+	}
+	if err := watcher.Add(path); err != nil {
+		log.Warningf("keyset: could not watch %s for changes: %s", path, err)
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				w.Refresh()
+			case <-w.closing:
+				return
+			}
+		}
+	}()
+}
+
+// watchSIGHUP forces an immediate refresh of every given watchedKeySet
+// whenever the process receives SIGHUP, so operators can push a takedown
+// without restarting the daemon.
+func watchSIGHUP(sets ...*watchedKeySet) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			for _, w := range sets {
+				w.Refresh()
+			}
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	migrate "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// maybeRunMigrations compares the on-disk repo version against the version
+// this binary expects and, if the repo is older, fetches and runs the chain
+// of migrations needed to bring it up to date. It must be called before
+// fsrepo.Open, which otherwise refuses to open a mismatched repo. When there
+// is no repo on disk (configRoot was never initialized), it is a no-op.
+func maybeRunMigrations(req cmds.Request) error {
+	configRoot := req.InvocContext().ConfigRoot
+
+	ver, err := fsrepo.Version(configRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// no repo on disk; nothing to migrate
+			return nil
+		}
+		return fmt.Errorf("repo-migrations: could not read repo version at %q: %s", configRoot, err)
+	}
+	if ver >= fsrepo.RepoVersion {
+		return nil
+	}
+
+	doMigrate, migrateSet, err := req.Option(migrateKwd).Bool()
+	if err != nil {
+		return err
+	}
+
+	if !migrateSet {
+		if !stdinIsTTY() {
+			return fmt.Errorf("repo-migrations: repo at %q is version %d, but this binary needs version %d; re-run with --migrate to upgrade automatically", configRoot, ver, fsrepo.RepoVersion)
+		}
+		doMigrate = promptRunMigrations(ver, fsrepo.RepoVersion)
+	}
+	if !doMigrate {
+		return errors.New("repo-migrations: migrations are required to run this version, but were declined")
+	}
+
+	fetcher := migrate.NewMultiFetcher(
+		migrate.NewHTTPFetcher(),
+		migrate.NewIpfsFetcher(),
+	)
+
+	log.Infof("repo-migrations: migrating repo from version %d to %d", ver, fsrepo.RepoVersion)
+	if err := migrate.RunMigration(fsrepo.RepoVersion, configRoot, fetcher); err != nil {
+		return fmt.Errorf("repo-migrations: migration failed: %s (your repo has not been modified)", err)
+	}
+	log.Info("repo-migrations: migration succeeded")
+	return nil
+}
+
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+func promptRunMigrations(from, to int) bool {
+	fmt.Printf("Found outdated repo at version %d, this software needs version %d.\n", from, to)
+	fmt.Print("Run migrations now? [y/N] ")
+
+	var resp string
+	fmt.Scanln(&resp)
+	return resp == "y" || resp == "Y"
+}
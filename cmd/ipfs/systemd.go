@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	manet "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr-net"
+)
+
+// systemdListeners returns any sockets passed to this process via the
+// systemd socket activation protocol (LISTEN_PID/LISTEN_FDS), keyed by the
+// name assigned to each socket in the unit file (FileDescriptorName=, shipped
+// to us via LISTEN_FDNAMES) and falling back to the socket's positional index
+// when it isn't named. It returns an empty map, not an error, when the
+// process wasn't started with any inherited sockets.
+func systemdListeners() (map[string]manet.Listener, error) {
+	listeners := make(map[string]manet.Listener)
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return listeners, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return listeners, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	const firstListenFd = 3
+	for i := 0; i < nfds; i++ {
+		fd := uintptr(firstListenFd + i)
+
+		name := strconv.Itoa(i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(fd, name)
+		nl, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemdListeners: failed to use inherited fd %d (%s): %s", fd, name, err)
+		}
+
+		ml, err := manet.WrapNetListener(nl)
+		if err != nil {
+			return nil, fmt.Errorf("systemdListeners: failed to wrap inherited listener %q: %s", name, err)
+		}
+		listeners[name] = ml
+	}
+
+	return listeners, nil
+}
+
+// sdNotify sends state to the systemd notification socket named by
+// $NOTIFY_SOCKET. It is a no-op when the daemon wasn't started by systemd.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
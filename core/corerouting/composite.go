@@ -0,0 +1,203 @@
+package corerouting
+
+import (
+	"errors"
+	"sync"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	routing "github.com/ipfs/go-ipfs/routing"
+)
+
+// Capability selects which operations a Route participates in. It mirrors
+// config.MethodName (see Custom): one field per routing operation.
+type Capability struct {
+	Provide       bool
+	FindProviders bool
+	FindPeers     bool
+	GetIPNS       bool
+	PutIPNS       bool
+}
+
+// allCapabilities is used for Routes nested inside a parallel/sequential
+// Router: such a Route isn't bound to a single config.MethodName, so it must
+// participate in every operation the enclosing Composite is asked to serve.
+var allCapabilities = Capability{Provide: true, FindProviders: true, FindPeers: true, GetIPNS: true, PutIPNS: true}
+
+// Route pairs an already-constructed sub-router with the operations it
+// should be consulted for.
+type Route struct {
+	Name string
+	routing.IpfsRouting
+	Capability
+}
+
+// Composite is a routing.IpfsRouting that fans each operation out across
+// the subset of its Routes enabled for that operation. In parallel mode,
+// eligible routes are queried concurrently and the first success wins; in
+// sequential mode they are tried one at a time, in the given order,
+// stopping at the first success.
+type Composite struct {
+	Parallel bool
+	Routes   []Route
+}
+
+var _ routing.IpfsRouting = (*Composite)(nil)
+
+func (c *Composite) eligible(pred func(Capability) bool) []Route {
+	var out []Route
+	for _, r := range c.Routes {
+		if pred(r.Capability) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (c *Composite) PutValue(ctx context.Context, k key.Key, val []byte) error {
+	routes := c.eligible(func(cap Capability) bool { return cap.PutIPNS })
+	return c.dispatch(routes, func(r Route) error {
+		return r.PutValue(ctx, k, val)
+	})
+}
+
+func (c *Composite) GetValue(ctx context.Context, k key.Key) ([]byte, error) {
+	routes := c.eligible(func(cap Capability) bool { return cap.GetIPNS })
+	var mu sync.Mutex
+	var val []byte
+	var got bool
+	err := c.dispatch(routes, func(r Route) error {
+		v, err := r.GetValue(ctx, k)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		if !got {
+			val, got = v, true
+		}
+		mu.Unlock()
+		return nil
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return val, err
+}
+
+func (c *Composite) Provide(ctx context.Context, k key.Key) error {
+	routes := c.eligible(func(cap Capability) bool { return cap.Provide })
+	return c.dispatch(routes, func(r Route) error {
+		return r.Provide(ctx, k)
+	})
+}
+
+func (c *Composite) FindProvidersAsync(ctx context.Context, k key.Key, count int) <-chan peer.PeerInfo {
+	routes := c.eligible(func(cap Capability) bool { return cap.FindProviders })
+	out := make(chan peer.PeerInfo)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]struct{})
+		var seenmu sync.Mutex
+		forward := func(r Route) {
+			for info := range r.FindProvidersAsync(ctx, k, count) {
+				seenmu.Lock()
+				_, dup := seen[string(info.ID)]
+				seen[string(info.ID)] = struct{}{}
+				seenmu.Unlock()
+				if dup {
+					continue
+				}
+				select {
+				case out <- info:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		if c.Parallel {
+			var wg sync.WaitGroup
+			for _, r := range routes {
+				wg.Add(1)
+				go func(r Route) {
+					defer wg.Done()
+					forward(r)
+				}(r)
+			}
+			wg.Wait()
+		} else {
+			for _, r := range routes {
+				forward(r)
+			}
+		}
+	}()
+
+	return out
+}
+
+func (c *Composite) FindPeer(ctx context.Context, p peer.ID) (peer.PeerInfo, error) {
+	routes := c.eligible(func(cap Capability) bool { return cap.FindPeers })
+	var mu sync.Mutex
+	var info peer.PeerInfo
+	var got bool
+	err := c.dispatch(routes, func(r Route) error {
+		i, err := r.FindPeer(ctx, p)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		if !got {
+			info, got = i, true
+		}
+		mu.Unlock()
+		return nil
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return info, err
+}
+
+func (c *Composite) Bootstrap(ctx context.Context) error {
+	return c.dispatch(c.Routes, func(r Route) error {
+		return r.Bootstrap(ctx)
+	})
+}
+
+// dispatch runs fn against routes, either in parallel (returning as soon as
+// one succeeds) or sequentially in order (stopping at the first success),
+// returning the last error seen if none of them succeeded.
+func (c *Composite) dispatch(routes []Route, fn func(Route) error) error {
+	if len(routes) == 0 {
+		return errors.New("corerouting: no sub-router configured for this operation")
+	}
+
+	if !c.Parallel {
+		var lastErr error
+		for _, r := range routes {
+			if err := fn(r); err == nil {
+				return nil
+			} else {
+				lastErr = err
+			}
+		}
+		return lastErr
+	}
+
+	results := make(chan error, len(routes))
+	for _, r := range routes {
+		go func(r Route) { results <- fn(r) }(r)
+	}
+
+	var lastErr error
+	for i := 0; i < len(routes); i++ {
+		if err := <-results; err == nil {
+			return nil
+		} else {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,178 @@
+package corerouting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	ma "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	"github.com/ipfs/go-ipfs/core"
+	host "github.com/ipfs/go-ipfs/p2p/host"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	routing "github.com/ipfs/go-ipfs/routing"
+	ipfsaddr "github.com/ipfs/go-ipfs/util/ipfsaddr"
+)
+
+// Custom builds a core.RoutingOption for Routing.Type = "custom": rcfg.Methods
+// binds each routing operation to a named entry of rcfg.Routers, which is
+// resolved recursively so "parallel"/"sequential" entries can nest other
+// named routers into an arbitrarily deep module chain.
+func Custom(rcfg config.Routing) core.RoutingOption {
+	return func(ctx context.Context, h host.Host, dstore ds.Datastore, pstore peer.Peerstore) (routing.IpfsRouting, error) {
+		resolver := &routerResolver{
+			ctx: ctx, h: h, dstore: dstore, pstore: pstore,
+			cfg:      rcfg.Routers,
+			built:    make(map[string]routing.IpfsRouting, len(rcfg.Routers)),
+			building: make(map[string]bool, len(rcfg.Routers)),
+		}
+
+		var routes []Route
+		for methodName, method := range rcfg.Methods {
+			r, err := resolver.resolve(method.RouterName)
+			if err != nil {
+				return nil, fmt.Errorf("corerouting: building Routing.Methods.%s: %s", methodName, err)
+			}
+			routes = append(routes, Route{
+				Name:        method.RouterName,
+				IpfsRouting: r,
+				Capability:  capabilityForMethod(methodName),
+			})
+		}
+		if len(routes) == 0 {
+			return nil, fmt.Errorf("corerouting: custom routing selected but Routing.Methods is empty")
+		}
+
+		return &Composite{Routes: routes}, nil
+	}
+}
+
+// routerResolver builds the named Routing.Routers pool on demand, caching
+// each entry the first time it's referenced (by a Methods binding or by a
+// parallel/sequential entry nesting it) and detecting reference cycles.
+type routerResolver struct {
+	ctx    context.Context
+	h      host.Host
+	dstore ds.Datastore
+	pstore peer.Peerstore
+
+	cfg      map[string]config.Router
+	built    map[string]routing.IpfsRouting
+	building map[string]bool
+}
+
+func (rr *routerResolver) resolve(name string) (routing.IpfsRouting, error) {
+	if r, ok := rr.built[name]; ok {
+		return r, nil
+	}
+	if rr.building[name] {
+		return nil, fmt.Errorf("Routing.Routers.%s: cycle in router references", name)
+	}
+	rcfg, ok := rr.cfg[name]
+	if !ok {
+		return nil, fmt.Errorf("Routing.Routers.%s is not defined", name)
+	}
+
+	rr.building[name] = true
+	defer delete(rr.building, name)
+
+	r, err := rr.build(rcfg)
+	if err != nil {
+		return nil, fmt.Errorf("Routing.Routers.%s: %s", name, err)
+	}
+	rr.built[name] = r
+	return r, nil
+}
+
+func (rr *routerResolver) build(rcfg config.Router) (routing.IpfsRouting, error) {
+	switch rcfg.Type {
+	case config.RouterTypeDHT:
+		return core.DHTOption(rr.ctx, rr.h, rr.dstore, rr.pstore)
+
+	case config.RouterTypeDHTClient:
+		return core.DHTClientOption(rr.ctx, rr.h, rr.dstore, rr.pstore)
+
+	case config.RouterTypeSupernodeClient:
+		infos, err := parseSupernodeServers(rcfg.Parameters["Servers"])
+		if err != nil {
+			return nil, err
+		}
+		return SupernodeClient(infos...)(rr.ctx, rr.h, rr.dstore, rr.pstore)
+
+	case config.RouterTypeHTTP:
+		endpoint := rcfg.Parameters["Endpoint"]
+		if endpoint == "" {
+			return nil, fmt.Errorf("http router requires a non-empty Endpoint parameter")
+		}
+		timeout := 30 * time.Second
+		if s := rcfg.Parameters["TimeoutSecs"]; s != "" {
+			secs, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TimeoutSecs %q: %s", s, err)
+			}
+			timeout = time.Duration(secs) * time.Second
+		}
+		return NewHTTPRouting(endpoint, timeout), nil
+
+	case config.RouterTypeParallel, config.RouterTypeSequential:
+		if len(rcfg.Routers) == 0 {
+			return nil, fmt.Errorf("%s router requires at least one entry in Routers", rcfg.Type)
+		}
+		var routes []Route
+		for _, sub := range rcfg.Routers {
+			r, err := rr.resolve(sub)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, Route{Name: sub, IpfsRouting: r, Capability: allCapabilities})
+		}
+		return &Composite{Parallel: rcfg.Type == config.RouterTypeParallel, Routes: routes}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown router type %q", rcfg.Type)
+	}
+}
+
+// capabilityForMethod turns a config.MethodName into the single Capability
+// flag it corresponds to.
+func capabilityForMethod(name config.MethodName) Capability {
+	switch name {
+	case config.MethodNameProvide:
+		return Capability{Provide: true}
+	case config.MethodNameFindProviders:
+		return Capability{FindProviders: true}
+	case config.MethodNameFindPeers:
+		return Capability{FindPeers: true}
+	case config.MethodNameGetIPNS:
+		return Capability{GetIPNS: true}
+	case config.MethodNamePutIPNS:
+		return Capability{PutIPNS: true}
+	default:
+		return Capability{}
+	}
+}
+
+// parseSupernodeServers parses a comma-separated list of IPFS multiaddrs
+// (the same format as SupernodeRouting.Servers) into peer.PeerInfo values.
+func parseSupernodeServers(s string) ([]peer.PeerInfo, error) {
+	var infos []peer.PeerInfo
+	for _, s := range strings.Split(s, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		a, err := ipfsaddr.ParseString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid supernode server address %q: %s", s, err)
+		}
+		infos = append(infos, peer.PeerInfo{ID: a.ID(), Addrs: []ma.Multiaddr{a.Transport()}})
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("supernode-client router requires a non-empty Servers parameter")
+	}
+	return infos, nil
+}
@@ -0,0 +1,98 @@
+package corerouting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	config "github.com/ipfs/go-ipfs/repo/config"
+)
+
+// failingHandler always answers with a server error, so a sequential group
+// routed through it first is forced to fall through to its next entry.
+func failingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// okHandler answers every request with 200, which is all Provide needs.
+func okHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// TestCustomNestedRouters builds a two-level module chain (a "parallel"
+// router nesting a "sequential" router alongside a plain leaf) purely from
+// config.Routing, and checks that Provide() succeeds once it reaches a
+// working leaf - i.e. the nesting is real, not flattened into one group.
+func TestCustomNestedRouters(t *testing.T) {
+	failing := httptest.NewServer(failingHandler())
+	defer failing.Close()
+	ok := httptest.NewServer(okHandler())
+	defer ok.Close()
+	third := httptest.NewServer(failingHandler())
+	defer third.Close()
+
+	rcfg := config.Routing{
+		Routers: map[string]config.Router{
+			"leaf-failing": {Type: config.RouterTypeHTTP, Parameters: map[string]string{"Endpoint": failing.URL}},
+			"leaf-ok":      {Type: config.RouterTypeHTTP, Parameters: map[string]string{"Endpoint": ok.URL}},
+			"leaf-third":   {Type: config.RouterTypeHTTP, Parameters: map[string]string{"Endpoint": third.URL}},
+			"seq-group":    {Type: config.RouterTypeSequential, Routers: []string{"leaf-failing", "leaf-ok"}},
+			"top-group":    {Type: config.RouterTypeParallel, Routers: []string{"seq-group", "leaf-third"}},
+		},
+		Methods: map[config.MethodName]config.Method{
+			config.MethodNameProvide: {RouterName: "top-group"},
+		},
+	}
+
+	r, err := Custom(rcfg)(context.Background(), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Custom: %s", err)
+	}
+
+	top, ok2 := r.(*Composite)
+	if !ok2 {
+		t.Fatalf("expected top-level router to be a *Composite, got %T", r)
+	}
+	if len(top.Routes) != 1 {
+		t.Fatalf("expected one Route bound to the provide method, got %d", len(top.Routes))
+	}
+	if _, ok2 := top.Routes[0].IpfsRouting.(*Composite); !ok2 {
+		t.Fatalf("expected Routing.Methods.provide's router to resolve to a nested *Composite (top-group), got %T", top.Routes[0].IpfsRouting)
+	}
+
+	// seq-group fails on leaf-failing then succeeds on leaf-ok; top-group is
+	// parallel so it should succeed overall even though leaf-third also fails.
+	if err := r.Provide(context.Background(), key.Key("nested-routing-test-key")); err != nil {
+		t.Errorf("Provide through nested parallel/sequential chain failed: %s", err)
+	}
+}
+
+// TestCustomRouterCycle checks that a sequential/parallel router referencing
+// itself (directly or transitively) is rejected instead of recursing forever.
+func TestCustomRouterCycle(t *testing.T) {
+	rcfg := config.Routing{
+		Routers: map[string]config.Router{
+			"a": {Type: config.RouterTypeSequential, Routers: []string{"b"}},
+			"b": {Type: config.RouterTypeSequential, Routers: []string{"a"}},
+		},
+		Methods: map[config.MethodName]config.Method{
+			config.MethodNameProvide: {RouterName: "a"},
+		},
+	}
+
+	_, err := Custom(rcfg)(context.Background(), nil, nil, nil)
+	if err == nil {
+		t.Fatal("expected an error for a cyclic router chain, got nil")
+	}
+	if !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected a cycle-detection error, got: %s", err)
+	}
+}
@@ -0,0 +1,197 @@
+package corerouting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	ma "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multiaddr"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+)
+
+// wireProviderInfo is the JSON shape exchanged with the delegated routing
+// endpoint for a single provider.
+type wireProviderInfo struct {
+	ID    string
+	Addrs []string
+}
+
+func decodePeerInfo(r io.Reader, out *peer.PeerInfo) error {
+	var w wireProviderInfo
+	if err := json.NewDecoder(r).Decode(&w); err != nil {
+		return err
+	}
+	info, err := wireProviderInfo2PeerInfo(w)
+	if err != nil {
+		return err
+	}
+	*out = info
+	return nil
+}
+
+func decodePeerInfos(r io.Reader, out *[]peer.PeerInfo) error {
+	var ws []wireProviderInfo
+	if err := json.NewDecoder(r).Decode(&ws); err != nil {
+		return err
+	}
+	for _, w := range ws {
+		info, err := wireProviderInfo2PeerInfo(w)
+		if err != nil {
+			continue
+		}
+		*out = append(*out, info)
+	}
+	return nil
+}
+
+func wireProviderInfo2PeerInfo(w wireProviderInfo) (peer.PeerInfo, error) {
+	id, err := peer.IDB58Decode(w.ID)
+	if err != nil {
+		return peer.PeerInfo{}, err
+	}
+	info := peer.PeerInfo{ID: id}
+	for _, a := range w.Addrs {
+		maddr, err := ma.NewMultiaddr(a)
+		if err != nil {
+			continue
+		}
+		info.Addrs = append(info.Addrs, maddr)
+	}
+	return info, nil
+}
+
+// httpRouting is a routing.IpfsRouting that delegates every operation to a
+// remote HTTP endpoint, used by the "http" router type of a custom routing
+// chain (see Custom). It speaks a small REST-ish protocol: GET/PUT /{key} for
+// values, POST /provide/{key} to announce, GET /providers/{key} to look them
+// up (a JSON array of {ID, Addrs} objects), and GET /findpeer/{id} (a single
+// such JSON object).
+type httpRouting struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewHTTPRouting returns a routing.IpfsRouting backed by a delegated routing
+// endpoint at url, bounded by timeout.
+func NewHTTPRouting(url string, timeout time.Duration) *httpRouting {
+	return &httpRouting{
+		endpoint: url,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *httpRouting) PutValue(ctx context.Context, k key.Key, val []byte) error {
+	req, err := http.NewRequest("PUT", c.endpoint+"/"+key.B58KeyEncode(k), bytes.NewReader(val))
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("corerouting: http PutValue to %s failed: %s", c.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (c *httpRouting) GetValue(ctx context.Context, k key.Key) ([]byte, error) {
+	req, err := http.NewRequest("GET", c.endpoint+"/"+key.B58KeyEncode(k), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("corerouting: http GetValue from %s failed: %s", c.endpoint, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *httpRouting) Provide(ctx context.Context, k key.Key) error {
+	req, err := http.NewRequest("POST", c.endpoint+"/provide/"+key.B58KeyEncode(k), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("corerouting: http Provide to %s failed: %s", c.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (c *httpRouting) FindProvidersAsync(ctx context.Context, k key.Key, count int) <-chan peer.PeerInfo {
+	out := make(chan peer.PeerInfo)
+	go func() {
+		defer close(out)
+
+		url := fmt.Sprintf("%s/providers/%s?count=%d", c.endpoint, key.B58KeyEncode(k), count)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return
+		}
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return
+		}
+
+		var infos []peer.PeerInfo
+		if err := decodePeerInfos(resp.Body, &infos); err != nil {
+			return
+		}
+		for _, info := range infos {
+			select {
+			case out <- info:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (c *httpRouting) FindPeer(ctx context.Context, p peer.ID) (peer.PeerInfo, error) {
+	req, err := http.NewRequest("GET", c.endpoint+"/findpeer/"+p.Pretty(), nil)
+	if err != nil {
+		return peer.PeerInfo{}, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return peer.PeerInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return peer.PeerInfo{}, fmt.Errorf("corerouting: http FindPeer from %s failed: %s", c.endpoint, resp.Status)
+	}
+
+	var info peer.PeerInfo
+	if err := decodePeerInfo(resp.Body, &info); err != nil {
+		return peer.PeerInfo{}, err
+	}
+	return info, nil
+}
+
+// Bootstrap is a no-op: an HTTP delegated routing endpoint has nothing to
+// bootstrap on our side.
+func (c *httpRouting) Bootstrap(ctx context.Context) error {
+	return nil
+}
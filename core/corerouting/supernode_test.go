@@ -0,0 +1,78 @@
+package corerouting
+
+import (
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	key "github.com/ipfs/go-ipfs/blocks/key"
+	mocknet "github.com/ipfs/go-ipfs/p2p/net/mock"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	routing "github.com/ipfs/go-ipfs/routing"
+)
+
+// TestSupernodeRouting spins up a handful of supernode servers and clients
+// over a mocknet (no DHT anywhere in the picture), has one client provide a
+// key, and checks that every other client can find it through the servers.
+func TestSupernodeRouting(t *testing.T) {
+	const nServers = 2
+	const nClients = 3
+
+	mn := mocknet.New(context.Background())
+
+	var serverInfos []peer.PeerInfo
+	for i := 0; i < nServers; i++ {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := SupernodeServer(ds.NewMapDatastore())(context.Background(), h, ds.NewMapDatastore(), h.Peerstore()); err != nil {
+			t.Fatalf("SupernodeServer: %s", err)
+		}
+		serverInfos = append(serverInfos, peer.PeerInfo{ID: h.ID(), Addrs: h.Addrs()})
+	}
+
+	var clientIDs []peer.ID
+	var clientRouters []routing.IpfsRouting
+	for i := 0; i < nClients; i++ {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := SupernodeClient(serverInfos...)(context.Background(), h, ds.NewMapDatastore(), h.Peerstore())
+		if err != nil {
+			t.Fatalf("SupernodeClient: %s", err)
+		}
+		clientIDs = append(clientIDs, h.ID())
+		clientRouters = append(clientRouters, r)
+	}
+
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	k := key.Key("supernode-routing-test-key")
+	if err := clientRouters[0].Provide(ctx, k); err != nil {
+		t.Fatalf("Provide: %s", err)
+	}
+
+	for i := 1; i < nClients; i++ {
+		var found bool
+		for info := range clientRouters[i].FindProvidersAsync(ctx, k, 1) {
+			if info.ID == clientIDs[0] {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("client %d did not find client 0 as a provider of %s via the supernode servers", i, k)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package config
+
+// Gateway configures the HTTP gateway exposed by `ipfs daemon` (and `ipfs
+// mount`'s HTTP mode), i.e. everything read as cfg.Gateway.* elsewhere in
+// this tree.
+type Gateway struct {
+	// RootRedirect is the path (if any) requests to "/" are redirected to.
+	RootRedirect string
+
+	// Writable allows PUT/POST/DELETE against the gateway, not just GET.
+	Writable bool
+
+	// BlackList and WhiteList each name a key set source (http(s)://,
+	// file://, or ipfs:// URL) consulted by the gateway's request filter;
+	// see cmd/ipfs/keyset.go.
+	BlackList string
+	WhiteList string
+
+	// ListRefreshInterval is how often, in seconds, BlackList/WhiteList are
+	// re-fetched in the background so updates take effect without a daemon
+	// restart. 0 means "use the daemon's default".
+	ListRefreshInterval int
+}
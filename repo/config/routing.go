@@ -0,0 +1,57 @@
+package config
+
+// Routing configures this node's content/peer/IPNS routing system, selected
+// via `ipfs daemon --routing=custom` (the DHT- and supernode-based options
+// are selected via the `--routing` CLI flag too, falling back to
+// SupernodeRouting.Server when it is unset; see daemon.go).
+//
+// A custom chain is built from two maps: Routers is a named pool of router
+// definitions, and Methods binds each routing operation to the pool entry
+// that should serve it. A Routers entry of type "parallel" or "sequential"
+// names other Routers entries in its own Routers field instead of Parameters,
+// so a method can be served by an arbitrarily deep tree of sub-routers
+// (e.g. a "parallel" of two "sequential" groups).
+type Routing struct {
+	Routers map[string]Router
+	Methods map[MethodName]Method
+}
+
+// MethodName is one of the routing operations a Methods entry binds to a
+// Routers entry.
+type MethodName string
+
+const (
+	MethodNameProvide       MethodName = "provide"
+	MethodNameFindProviders MethodName = "find-providers"
+	MethodNameFindPeers     MethodName = "find-peers"
+	MethodNameGetIPNS       MethodName = "get-ipns"
+	MethodNamePutIPNS       MethodName = "put-ipns"
+)
+
+// Method names the Routers entry that should serve one routing operation.
+type Method struct {
+	RouterName string
+}
+
+// RouterType is one of the router kinds a Router entry can build.
+type RouterType string
+
+const (
+	RouterTypeDHT             RouterType = "dht"
+	RouterTypeDHTClient       RouterType = "dht-client"
+	RouterTypeSupernodeClient RouterType = "supernode-client"
+	RouterTypeHTTP            RouterType = "http"
+	RouterTypeParallel        RouterType = "parallel"
+	RouterTypeSequential      RouterType = "sequential"
+)
+
+// Router is one named entry of Routing.Routers. The leaf types (dht,
+// dht-client, supernode-client, http) take their settings from Parameters;
+// parallel and sequential instead nest other named Routers entries, listed
+// in Routers, and are queried concurrently (parallel, first success wins) or
+// in order (sequential, stopping at the first success).
+type Router struct {
+	Type       RouterType
+	Parameters map[string]string
+	Routers    []string
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	ipfsaddr "github.com/ipfs/go-ipfs/util/ipfsaddr"
+)
+
+// SupernodeRouting configures this node's participation in supernode-style
+// (non-DHT) content routing, selected via `ipfs daemon --routing=supernode`
+// or `--routing=supernode-server`.
+type SupernodeRouting struct {
+	// Servers lists the supernode servers a supernode-client node dials,
+	// as multiaddrs carrying a peer ID, e.g.
+	// "/ip4/1.2.3.4/tcp/4001/ipfs/QmServer...".
+	Servers []string
+
+	// Server, if true, runs this node as a supernode server instead of a
+	// client: it answers routing queries for the clients configured with
+	// it in their Servers list, rather than issuing queries of its own.
+	Server bool
+}
+
+// ServerIPFSAddrs parses Servers into ipfsaddr.IPFSAddr values.
+func (r *SupernodeRouting) ServerIPFSAddrs() ([]ipfsaddr.IPFSAddr, error) {
+	var addrs []ipfsaddr.IPFSAddr
+	for _, s := range r.Servers {
+		a, err := ipfsaddr.ParseString(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}